@@ -42,6 +42,7 @@ cleanup_sample_apps() {
 
     # Delete secret
     kubectl -n $SAMPLE_APP_NAMESPACE delete secret s3-credentials 2>/dev/null || print_warn "Secret not found"
+    kubectl -n $SAMPLE_APP_NAMESPACE delete secret rgw-ca-cert 2>/dev/null || true
 
     print_info "Sample applications cleaned up!"
 }
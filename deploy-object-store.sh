@@ -52,6 +52,11 @@ print_header() {
     echo -e "${BLUE}========================================${NC}"
 }
 
+# Escape a value for the replacement side of a sed s|...|...| expression
+sed_escape() {
+    printf '%s' "$1" | sed -e 's/[\\&|]/\\&/g'
+}
+
 # Check if Rook Ceph is running
 check_rook_ceph() {
     print_info "Checking if Rook Ceph cluster is running..."
@@ -146,7 +151,7 @@ generate_tls_certificate() {
         -out "$cert_dir/tls.crt" \
         -days "$TLS_CERT_DAYS" \
         -subj "/CN=$TLS_CERT_DOMAIN" \
-        -addext "subjectAltName=DNS:$TLS_CERT_DOMAIN,DNS:*.rook-ceph.svc,DNS:*.rook-ceph.svc.cluster.local,DNS:localhost,IP:127.0.0.1"
+        -addext "subjectAltName=DNS:$TLS_CERT_DOMAIN,DNS:*.$ROOK_CEPH_NAMESPACE.svc,DNS:*.$ROOK_CEPH_NAMESPACE.svc.cluster.local,DNS:localhost,IP:127.0.0.1"
 
     # Save certificate to local directory for user reference
     local ca_cert_path="$SCRIPT_DIR/rgw-ca-cert.pem"
@@ -363,10 +368,13 @@ get_credentials() {
     ENDPOINT=$(kubectl -n $ROOK_CEPH_NAMESPACE get svc rook-ceph-rgw-$OBJECT_STORE_NAME -o jsonpath='{.spec.clusterIP}')
     PORT=$(kubectl -n $ROOK_CEPH_NAMESPACE get svc rook-ceph-rgw-$OBJECT_STORE_NAME -o jsonpath='{.spec.ports[0].port}')
 
-    # Determine protocol based on TLS setting
+    # Determine protocol based on TLS setting. Over TLS, use the service
+    # name the certificate was issued for, since it has no clusterIP SAN
     local protocol="http"
+    local host="$ENDPOINT"
     if [ "$ENABLE_TLS" = "true" ]; then
         protocol="https"
+        host="rook-ceph-rgw-$OBJECT_STORE_NAME.$ROOK_CEPH_NAMESPACE.svc"
     fi
 
     # Get access key and secret key
@@ -375,14 +383,14 @@ get_credentials() {
     SECRET_KEY=$(kubectl -n $ROOK_CEPH_NAMESPACE get secret rook-ceph-object-user-$OBJECT_STORE_NAME-$OBJECT_STORE_USER -o jsonpath='{.data.SecretKey}' | base64 --decode)
 
     echo ""
-    echo -e "${GREEN}S3 Endpoint:${NC} ${protocol}://${ENDPOINT}:${PORT}"
+    echo -e "${GREEN}S3 Endpoint:${NC} ${protocol}://${host}:${PORT}"
     echo -e "${GREEN}TLS Enabled:${NC} ${ENABLE_TLS}"
     echo -e "${GREEN}Access Key:${NC}  ${ACCESS_KEY}"
     echo -e "${GREEN}Secret Key:${NC}  ${SECRET_KEY}"
     echo ""
 
     # Export for use in sample apps
-    export S3_ENDPOINT="${protocol}://${ENDPOINT}:${PORT}"
+    export S3_ENDPOINT="${protocol}://${host}:${PORT}"
     export S3_ACCESS_KEY="${ACCESS_KEY}"
     export S3_SECRET_KEY="${SECRET_KEY}"
     export S3_USE_TLS="${ENABLE_TLS}"
@@ -395,6 +403,17 @@ deploy_sample_app() {
     # Create namespace if it doesn't exist
     kubectl create namespace $SAMPLE_APP_NAMESPACE --dry-run=client -o yaml | kubectl apply -f -
 
+    # Share the self-signed RGW certificate so the job can verify it;
+    # ca-cert is left empty without TLS so the job trusts the system pool
+    local ca_cert=""
+    if [ "$ENABLE_TLS" = "true" ]; then
+        print_info "Creating secret with the RGW CA certificate..."
+        kubectl -n $SAMPLE_APP_NAMESPACE create secret generic rgw-ca-cert \
+            --from-file=ca.crt="$SCRIPT_DIR/rgw-ca-cert.pem" \
+            --dry-run=client -o yaml | kubectl apply -f -
+        ca_cert="/etc/rgw-ca/ca.crt"
+    fi
+
     # Create secret with S3 credentials
     print_info "Creating secret with S3 credentials..."
     kubectl -n $SAMPLE_APP_NAMESPACE create secret generic s3-credentials \
@@ -402,6 +421,7 @@ deploy_sample_app() {
         --from-literal=access-key="$S3_ACCESS_KEY" \
         --from-literal=secret-key="$S3_SECRET_KEY" \
         --from-literal=use-tls="$S3_USE_TLS" \
+        --from-literal=ca-cert="$ca_cert" \
         --dry-run=client -o yaml | kubectl apply -f -
 
     # Read Python source files
@@ -414,8 +434,17 @@ deploy_sample_app() {
 
     # Replace content placeholders with actual Python code (properly indented for YAML)
     # Indent the Python script content with 4 spaces for YAML formatting
-    # Create temp files with indented content
-    sed 's/^/    /' "$SCRIPT_DIR/manifests/sample-apps/python-s3-test/test_s3.py" > /tmp/python_indented.tmp
+    # Create temp files with indented content, stamping the build's version,
+    # commit and date into the script for --version and JSON reports
+    local version=$(git -C "$SCRIPT_DIR" describe --tags --always --dirty 2>/dev/null || echo "dev")
+    local git_commit=$(git -C "$SCRIPT_DIR" rev-parse --short HEAD 2>/dev/null || echo "unknown")
+    local build_date=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+    # A tag or branch can contain "/" (e.g. release/1.2), so use "|" and escape the values
+    sed -e "s|^VERSION = \"dev\"$|VERSION = \"$(sed_escape "$version")\"|" \
+        -e "s|^GIT_COMMIT = \"unknown\"$|GIT_COMMIT = \"$(sed_escape "$git_commit")\"|" \
+        -e "s|^BUILD_DATE = \"unknown\"$|BUILD_DATE = \"$(sed_escape "$build_date")\"|" \
+        -e 's/^/    /' \
+        "$SCRIPT_DIR/manifests/sample-apps/python-s3-test/test_s3.py" > /tmp/python_indented.tmp
     sed 's/^/    /' "$SCRIPT_DIR/manifests/sample-apps/python-s3-test/requirements.txt" > /tmp/requirements_indented.tmp
 
     # Use sed to replace placeholders with file contents (works on both macOS and Linux)
@@ -0,0 +1,46 @@
+"""The S3 client the unit tests run against: boto3, answered by --mock's MockS3."""
+import io
+import sys
+
+import boto3
+
+import test_s3
+
+
+def parse(*argv):
+    """The arguments test_s3.py would parse from its command line argv, with --mock."""
+    saved = sys.argv
+    sys.argv = ["test_s3.py", *argv, "--mock"]
+    try:
+        return test_s3.parse_args()
+    finally:
+        sys.argv = saved
+
+
+class MockClient:
+    """
+    A boto3 S3 client whose requests MockS3 answers, with the S3 calls it
+    made and what the tool logged. The calls are (operation name, API
+    parameters) pairs, recorded before --mock sees them.
+    """
+
+    def __init__(self):
+        self.s3 = boto3.client('s3', endpoint_url=test_s3.MOCK_ENDPOINT, region_name=test_s3.DEFAULT_REGION,
+                               aws_access_key_id=test_s3.MOCK_ACCESS_KEY,
+                               aws_secret_access_key=test_s3.MOCK_SECRET_KEY)
+        self.calls = []
+        self.s3.meta.events.register('before-parameter-build.s3', self._record)
+        self.backend = test_s3.MockS3()
+        self.backend.attach(self.s3)
+        self.output = io.StringIO()
+        test_s3.LOG_STREAM = self.output
+
+    def _record(self, params, model, **kwargs):
+        self.calls.append((model.name, dict(params)))
+
+    def called(self, operation):
+        """The parameters of every call of operation, in order."""
+        return [params for name, params in self.calls if name == operation]
+
+    def log(self):
+        return self.output.getvalue()
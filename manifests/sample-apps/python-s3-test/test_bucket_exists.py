@@ -0,0 +1,58 @@
+"""A bucket left over from a prior run is acknowledged, and the run goes on to upload."""
+import unittest
+
+import test_s3
+from mock_client import MockClient, parse
+
+
+class BucketExistsTest(unittest.TestCase):
+    def setUp(self):
+        self.client = MockClient()
+        self.report = test_s3.Report("text")
+
+    def put(self):
+        args = parse("put", "--bucket", "b", "--key", "k", "--random-size", "1KiB")
+        test_s3.run_put_mode(self.client.s3, args, self.report)
+
+    def step(self, name):
+        return next(step for step in self.report.steps if step.name == name)
+
+    def _exists_elsewhere(self, model, **kwargs):
+        # Ahead of --mock: the name is taken, by us or by another tenant
+        return test_s3._MockResponse(409), {
+            'Error': {'Code': 'BucketAlreadyExists', 'Message': "The requested bucket name is not available"},
+            'ResponseMetadata': {'HTTPStatusCode': 409, 'RequestId': "mock-exists"},
+        }
+
+    def test_already_owned_proceeds_to_upload(self):
+        self.client.s3.create_bucket(Bucket="b")
+        self.put()
+        # The second CreateBucket, from the run, got BucketAlreadyOwnedByYou
+        self.assertEqual(len(self.client.called('CreateBucket')), 2)
+        self.assertIn("✓ Bucket already exists (owned by you)", self.client.log())
+        self.assertTrue(self.step("create-bucket").success)
+        self.assertTrue(self.step("put-object").success)
+        self.assertEqual(len(self.client.called('PutObject')), 1)
+        self.assertIn("k", list(self.client.backend.buckets["b"]["objects"]))
+
+    def test_already_exists_and_accessible(self):
+        self.client.s3.create_bucket(Bucket="b")
+        self.client.s3.meta.events.register_first('before-call.s3.CreateBucket', self._exists_elsewhere)
+        self.put()
+        self.assertIn("✓ Bucket already exists\n", self.client.log())
+        self.assertEqual(len(self.client.called('HeadBucket')), 2)
+        self.assertEqual(len(self.client.called('PutObject')), 1)
+
+    def test_already_exists_for_someone_else(self):
+        # HeadBucket fails, so the name belongs to someone else: a real failure
+        self.client.s3.meta.events.register_first('before-call.s3.CreateBucket', self._exists_elsewhere)
+        with self.assertRaises(test_s3.S3APIError) as raised:
+            self.put()
+        self.assertIn("Failed to create bucket", str(raised.exception))
+        self.assertIn("(BucketAlreadyExists)", str(raised.exception))
+        self.assertFalse(self.step("create-bucket").success)
+        self.assertEqual(self.client.called('PutObject'), [])
+
+
+if __name__ == "__main__":
+    unittest.main()
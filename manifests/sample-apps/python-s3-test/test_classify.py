@@ -0,0 +1,125 @@
+"""classify_error and the exit codes api_error and sdk_error map errors to."""
+import socket
+import unittest
+import urllib.error
+
+from botocore.exceptions import (ClientError, ConnectionClosedError, EndpointConnectionError, NoCredentialsError,
+                                 ParamValidationError)
+
+import test_s3
+from test_s3 import ErrorKind
+
+# (error code, HTTP status, expected kind, expected exit code); the exit
+# code is that of the error api_error wraps the response in
+CLIENT_ERRORS = [
+    ("InvalidAccessKeyId", 403, ErrorKind.AUTH, test_s3.EXIT_CONFIG),
+    ("SignatureDoesNotMatch", 403, ErrorKind.AUTH, test_s3.EXIT_CONFIG),
+    ("ExpiredToken", 400, ErrorKind.AUTH, test_s3.EXIT_CONFIG),
+    ("InvalidToken", 400, ErrorKind.AUTH, test_s3.EXIT_CONFIG),
+    ("", 401, ErrorKind.AUTH, test_s3.EXIT_CONFIG),
+    ("RequestTimeTooSkewed", 403, ErrorKind.CONFIG, test_s3.EXIT_CONFIG),
+    ("AuthorizationHeaderMalformed", 400, ErrorKind.CONFIG, test_s3.EXIT_CONFIG),
+    ("NoSuchBucket", 404, ErrorKind.NOT_FOUND, test_s3.EXIT_NOT_FOUND),
+    ("NoSuchKey", 404, ErrorKind.NOT_FOUND, test_s3.EXIT_NOT_FOUND),
+    # A HEAD 404 carries no error code; api_error is told the key
+    ("404", 404, ErrorKind.NOT_FOUND, test_s3.EXIT_NOT_FOUND),
+    ("NoSuchUpload", 404, ErrorKind.NOT_FOUND, test_s3.EXIT_S3_API),
+    ("NoSuchVersion", 404, ErrorKind.NOT_FOUND, test_s3.EXIT_S3_API),
+    ("SlowDown", 503, ErrorKind.THROTTLED, test_s3.EXIT_S3_API),
+    ("Throttling", 400, ErrorKind.THROTTLED, test_s3.EXIT_S3_API),
+    ("", 429, ErrorKind.THROTTLED, test_s3.EXIT_S3_API),
+    ("InternalError", 500, ErrorKind.SERVER_ERROR, test_s3.EXIT_S3_API),
+    ("ServiceUnavailable", 503, ErrorKind.SERVER_ERROR, test_s3.EXIT_S3_API),
+    ("NotImplemented", 501, ErrorKind.SERVER_ERROR, test_s3.EXIT_S3_API),
+    ("AccessDenied", 403, ErrorKind.CLIENT_ERROR, test_s3.EXIT_S3_API),
+    ("InvalidArgument", 400, ErrorKind.CLIENT_ERROR, test_s3.EXIT_S3_API),
+    ("EntityTooLarge", 400, ErrorKind.CLIENT_ERROR, test_s3.EXIT_S3_API),
+    ("BucketNotEmpty", 409, ErrorKind.CLIENT_ERROR, test_s3.EXIT_S3_API),
+    ("PreconditionFailed", 412, ErrorKind.CLIENT_ERROR, test_s3.EXIT_S3_API),
+]
+
+# (SDK or socket error, expected kind, expected exit code of sdk_error)
+SDK_ERRORS = [
+    (NoCredentialsError(), ErrorKind.CONFIG, test_s3.EXIT_CONFIG),
+    (ParamValidationError(report="Invalid bucket name"), ErrorKind.CONFIG, test_s3.EXIT_CONFIG),
+    (EndpointConnectionError(endpoint_url="http://rgw"), ErrorKind.NETWORK, test_s3.EXIT_CONNECTIVITY),
+    (ConnectionClosedError(endpoint_url="http://rgw"), ErrorKind.NETWORK, test_s3.EXIT_CONNECTIVITY),
+    (socket.timeout("timed out"), ErrorKind.NETWORK, test_s3.EXIT_CONNECTIVITY),
+    (ConnectionRefusedError(111, "Connection refused"), ErrorKind.NETWORK, test_s3.EXIT_CONNECTIVITY),
+]
+
+# (our own error, with no SDK error behind it, expected kind)
+WRAPPERS = [
+    (test_s3.ConfigError("bad flag"), ErrorKind.CONFIG),
+    (test_s3.ConnectivityError("unreachable"), ErrorKind.NETWORK),
+    (test_s3.OperationTimeout("deadline"), ErrorKind.NETWORK),
+    (test_s3.NotFoundError("gone"), ErrorKind.NOT_FOUND),
+    (test_s3.ConsistencyError("not listed yet"), ErrorKind.NOT_FOUND),
+    (test_s3.S3APIError("rejected"), ErrorKind.CLIENT_ERROR),
+    (test_s3.EntityTooLarge("too big"), ErrorKind.CLIENT_ERROR),
+    (test_s3.VerificationError("mismatch"), ErrorKind.UNKNOWN),
+    (test_s3.S3TestError("other"), ErrorKind.UNKNOWN),
+]
+
+
+def client_error(code, status):
+    return ClientError({'Error': {'Code': code, 'Message': "test"}, 'ResponseMetadata': {'HTTPStatusCode': status}},
+                       'GetObject')
+
+
+def raised_from(wrap, cause):
+    """The error wrap returns, raised while handling cause as the tool does."""
+    try:
+        raise cause
+    except Exception:
+        try:
+            raise wrap(cause)
+        except test_s3.S3TestError as e:
+            return e
+
+
+class ClassifyTest(unittest.TestCase):
+    def test_client_errors(self):
+        for code, status, kind, exit_code in CLIENT_ERRORS:
+            with self.subTest(code=code, status=status):
+                e = client_error(code, status)
+                self.assertIs(test_s3.classify_error(e), kind)
+                wrapped = raised_from(lambda cause: test_s3.api_error("GetObject failed", cause, "b", "k"), e)
+                self.assertEqual(wrapped.exit_code, exit_code)
+                # The wrapper keeps the kind of the response behind it
+                self.assertIs(test_s3.classify_error(wrapped), kind)
+
+    def test_sdk_errors(self):
+        for e, kind, exit_code in SDK_ERRORS:
+            with self.subTest(error=type(e).__name__):
+                self.assertIs(test_s3.classify_error(e), kind)
+                wrapped = raised_from(lambda cause: test_s3.sdk_error("PutObject failed", cause), e)
+                self.assertEqual(wrapped.exit_code, exit_code)
+                self.assertIs(test_s3.classify_error(wrapped), kind)
+
+    def test_http_errors(self):
+        for status, kind in ((401, ErrorKind.AUTH), (403, ErrorKind.CLIENT_ERROR), (404, ErrorKind.NOT_FOUND),
+                             (429, ErrorKind.THROTTLED), (502, ErrorKind.SERVER_ERROR)):
+            with self.subTest(status=status):
+                e = urllib.error.HTTPError("http://rgw/b/k", status, "test", {}, None)
+                self.assertIs(test_s3.classify_error(e), kind)
+
+    def test_wrappers(self):
+        for e, kind in WRAPPERS:
+            with self.subTest(error=type(e).__name__):
+                self.assertIs(test_s3.classify_error(e), kind)
+
+    def test_timeout_hides_its_cause(self):
+        # A deadline interrupts whatever request was running; the request's
+        # own error says nothing about why the run failed
+        e = raised_from(lambda cause: test_s3.OperationTimeout("deadline"), client_error("InternalError", 500))
+        self.assertIs(test_s3.classify_error(e), ErrorKind.NETWORK)
+
+    def test_exit_codes_distinct(self):
+        codes = [test_s3.EXIT_OK, test_s3.EXIT_FAILURE, test_s3.EXIT_CONFIG, test_s3.EXIT_CONNECTIVITY,
+                 test_s3.EXIT_S3_API, test_s3.EXIT_VERIFICATION, test_s3.EXIT_NOT_FOUND, test_s3.EXIT_SLA]
+        self.assertEqual(len(set(codes)), len(codes))
+
+
+if __name__ == "__main__":
+    unittest.main()
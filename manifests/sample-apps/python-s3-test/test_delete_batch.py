@@ -0,0 +1,49 @@
+"""delete_objects_batch splits deletes into DeleteObjects calls of at most 1000 keys."""
+import unittest
+
+import test_s3
+from mock_client import MockClient
+
+
+class DeleteObjectsBatchTest(unittest.TestCase):
+    def setUp(self):
+        self.client = MockClient()
+        self.client.s3.create_bucket(Bucket="b")
+
+    def put(self, count):
+        keys = [f"k{n:04}" for n in range(count)]
+        for key in keys:
+            self.client.s3.put_object(Bucket="b", Key=key, Body=b"x")
+        return [{'Key': key} for key in keys]
+
+    def test_2500_keys_take_3_calls(self):
+        objects = self.put(2500)
+        failed = test_s3.delete_objects_batch(self.client.s3, "b", objects, quiet=True)
+        self.assertEqual(failed, [])
+        batches = [params['Delete']['Objects'] for params in self.client.called('DeleteObjects')]
+        self.assertEqual([len(batch) for batch in batches], [1000, 1000, 500])
+        self.assertTrue(all(len(batch) <= test_s3.DELETE_BATCH_SIZE for batch in batches))
+        # Every key is deleted exactly once
+        self.assertEqual(sorted(obj['Key'] for batch in batches for obj in batch),
+                         sorted(obj['Key'] for obj in objects))
+        self.assertNotIn('Contents', self.client.s3.list_objects_v2(Bucket="b"))
+
+    def test_exact_batch_size(self):
+        objects = self.put(test_s3.DELETE_BATCH_SIZE)
+        test_s3.delete_objects_batch(self.client.s3, "b", objects, quiet=True)
+        self.assertEqual(len(self.client.called('DeleteObjects')), 1)
+
+    def test_nothing_to_delete(self):
+        self.assertEqual(test_s3.delete_objects_batch(self.client.s3, "b", []), [])
+        self.assertEqual(self.client.called('DeleteObjects'), [])
+
+    def test_failed_batch_reported_per_key(self):
+        objects = self.put(3)
+        # Without the bucket, the whole DeleteObjects call fails
+        failed = test_s3.delete_objects_batch(self.client.s3, "missing", objects)
+        self.assertEqual([(key, code) for key, code, _ in failed],
+                         [(obj['Key'], 'NoSuchBucket') for obj in objects])
+
+
+if __name__ == "__main__":
+    unittest.main()
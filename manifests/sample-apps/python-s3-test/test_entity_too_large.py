@@ -0,0 +1,63 @@
+"""An EntityTooLarge PutObject is explained, and --auto-multipart falls back to a multipart upload."""
+import unittest
+
+import test_s3
+from mock_client import MockClient, parse
+
+# Stands in for rgw_max_put_size, far below RGW's 5 GiB default
+MAX_PUT_SIZE = 6 * 1024 * 1024
+
+
+class EntityTooLargeTest(unittest.TestCase):
+    def setUp(self):
+        self.client = MockClient()
+        self.client.s3.create_bucket(Bucket="b")
+        # Ahead of --mock, so oversized PutObjects are refused as RGW would
+        self.client.s3.meta.events.register_first('before-call.s3.PutObject', self._limit)
+
+    def _limit(self, model, context, **kwargs):
+        # put_object passes ContentLength, so the streamed body is left unread
+        if context['mock_params']['ContentLength'] <= MAX_PUT_SIZE:
+            return None
+        return test_s3._MockResponse(400), {
+            'Error': {'Code': 'EntityTooLarge', 'Message': "Your proposed upload exceeds the maximum allowed size"},
+            'ResponseMetadata': {'HTTPStatusCode': 400, 'RequestId': "mock-limit"},
+        }
+
+    def upload(self, size, *argv):
+        args = parse("put", "--bucket", "b", "--random-size", str(size), *argv)
+        return test_s3.upload_object(self.client.s3, "b", "big", test_s3.load_payload(args), args)
+
+    def test_hint(self):
+        with self.assertRaises(test_s3.EntityTooLarge) as raised:
+            self.upload(MAX_PUT_SIZE + 1)
+        message = str(raised.exception)
+        self.assertEqual(raised.exception.exit_code, test_s3.EXIT_S3_API)
+        self.assertIn(f"{MAX_PUT_SIZE + 1}-byte", message)
+        self.assertIn("--multipart", message)
+        self.assertIn("--auto-multipart", message)
+        self.assertIn(test_s3.ERROR_HINTS['EntityTooLarge'], message)
+        self.assertIn("rgw_max_put_size", message)
+        self.assertEqual(self.client.called('CreateMultipartUpload'), [])
+        self.assertNotIn("big", list(self.client.backend.buckets["b"]["objects"]))
+
+    def test_auto_multipart_falls_back(self):
+        size = 12 * 1024 * 1024
+        etag = self.upload(size, "--auto-multipart", "--part-size", "5MiB")
+        self.assertEqual(len(self.client.called('PutObject')), 1)
+        self.assertEqual(len(self.client.called('CreateMultipartUpload')), 1)
+        self.assertEqual(len(self.client.called('CompleteMultipartUpload')), 1)
+        self.assertTrue(etag.strip('"').endswith("-3"), etag)
+        stored = self.client.backend.buckets["b"]["objects"]["big"]
+        self.assertEqual(len(stored["data"]), size)
+        self.assertEqual(stored["etag"], etag)
+        self.assertIn("retrying as a multipart upload (--auto-multipart)", self.client.log())
+
+    def test_under_the_limit(self):
+        self.upload(MAX_PUT_SIZE, "--auto-multipart")
+        self.assertEqual(len(self.client.called('PutObject')), 1)
+        self.assertEqual(self.client.called('CreateMultipartUpload'), [])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,67 @@
+"""A missing bucket or key is a NotFoundError naming it, with its own exit code."""
+import json
+import os
+import subprocess
+import sys
+import unittest
+
+import test_s3
+from mock_client import MockClient, parse
+
+SCRIPT = os.path.join(os.path.dirname(os.path.abspath(__file__)), "test_s3.py")
+
+
+class NotFoundTest(unittest.TestCase):
+    def setUp(self):
+        self.client = MockClient()
+        self.client.s3.create_bucket(Bucket="b")
+
+    def assertNotFound(self, run, message):
+        with self.assertRaises(test_s3.NotFoundError) as raised:
+            run()
+        self.assertEqual(raised.exception.exit_code, test_s3.EXIT_NOT_FOUND)
+        self.assertIn(message, str(raised.exception))
+        self.assertIs(test_s3.classify_error(raised.exception), test_s3.ErrorKind.NOT_FOUND)
+
+    def run_mode(self, mode, *argv):
+        args = parse(*argv)
+        mode(self.client.s3, args, test_s3.Report("text"))
+
+    def test_no_such_bucket(self):
+        for mode, argv in ((test_s3.run_get_mode, ["get"]), (test_s3.run_ls_mode, ["ls"]),
+                           (test_s3.run_get_mode, ["get", "--object-attributes"])):
+            with self.subTest(argv=argv):
+                self.assertNotFound(lambda: self.run_mode(mode, *argv, "--bucket", "missing"),
+                                    "bucket missing does not exist (NoSuchBucket)")
+
+    def test_no_such_key(self):
+        for argv in (["get"], ["get", "--object-attributes"]):
+            with self.subTest(argv=argv):
+                self.assertNotFound(lambda: self.run_mode(test_s3.run_get_mode, *argv, "--bucket", "b",
+                                                          "--key", "nope"),
+                                    "b/nope does not exist")
+
+    def test_no_such_key_code(self):
+        # GET has a body, so the code is NoSuchKey rather than HEAD's bare 404
+        self.assertNotFound(lambda: self.run_mode(test_s3.run_get_mode, "get", "--bucket", "b", "--key", "nope"),
+                            "(NoSuchKey)")
+
+    def test_rm_missing_key_succeeds(self):
+        self.run_mode(test_s3.run_rm_mode, "rm", "--bucket", "b", "--key", "nope")
+        self.assertIn("Object does not exist, nothing to delete", self.client.log())
+
+
+class NotFoundExitCodeTest(unittest.TestCase):
+    def test_cli(self):
+        env = {name: value for name, value in os.environ.items() if not name.startswith("S3_")}
+        result = subprocess.run([sys.executable, SCRIPT, "get", "--mock", "--bucket", "missing", "--output", "json"],
+                                capture_output=True, text=True, env=env, timeout=120)
+        self.assertEqual(result.returncode, test_s3.EXIT_NOT_FOUND, result.stderr)
+        document = json.loads(result.stdout)
+        self.assertEqual(document["exit_code"], test_s3.EXIT_NOT_FOUND)
+        self.assertEqual(document["error_kind"], "not-found")
+        self.assertIn("bucket missing does not exist (NoSuchBucket)", document["error"])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,56 @@
+"""list_objects follows continuation tokens past the 1000-key page limit."""
+import io
+import json
+import unittest
+
+import test_s3
+from mock_client import MockClient
+
+
+class ListObjectsPaginationTest(unittest.TestCase):
+    def setUp(self):
+        self.client = MockClient()
+        self.client.s3.create_bucket(Bucket="b")
+        # 2500 keys: two truncated pages of 1000, then a last page of 500
+        self.keys = [f"dir{n % 3}/k{n:04}" for n in range(2500)]
+        for key in self.keys:
+            self.client.s3.put_object(Bucket="b", Key=key, Body=b"x")
+        self.records = io.StringIO()
+        test_s3.RECORD_STREAM = self.records
+
+    def tearDown(self):
+        test_s3.RECORD_STREAM = None
+
+    def listed(self):
+        return [json.loads(line) for line in self.records.getvalue().splitlines()]
+
+    def test_every_key_from_every_page(self):
+        total = test_s3.list_objects(self.client.s3, "b")
+        self.assertEqual(total, len(self.keys))
+        self.assertEqual(sorted(record['key'] for record in self.listed()), sorted(self.keys))
+        calls = self.client.called('ListObjectsV2')
+        self.assertEqual(len(calls), 3)
+        self.assertNotIn('ContinuationToken', calls[0])
+        self.assertTrue(all(call.get('ContinuationToken') for call in calls[1:]))
+        self.assertIn("Total: 2500 objects across 3 pages", self.client.log())
+
+    def test_prefix(self):
+        total = test_s3.list_objects(self.client.s3, "b", prefix="dir1/")
+        expected = [key for key in self.keys if key.startswith("dir1/")]
+        self.assertEqual(total, len(expected))
+        self.assertEqual(sorted(record['key'] for record in self.listed()), expected)
+
+    def test_max_keys_stops_across_pages(self):
+        total = test_s3.list_objects(self.client.s3, "b", max_keys=1500)
+        self.assertEqual(total, 1500)
+        self.assertEqual([record['key'] for record in self.listed()], sorted(self.keys)[:1500])
+        self.assertIn("truncated", self.client.log())
+
+    def test_expect_key_on_a_later_page(self):
+        # The key is only on the last page, and must still be found
+        last = sorted(self.keys)[-1]
+        self.assertEqual(test_s3.list_objects(self.client.s3, "b", expect_key=last), len(self.keys))
+
+
+if __name__ == "__main__":
+    unittest.main()
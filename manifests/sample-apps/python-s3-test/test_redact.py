@@ -0,0 +1,80 @@
+"""Secrets must never reach the log, the JSON output or the --report file."""
+import base64
+import json
+import os
+import subprocess
+import sys
+import tempfile
+import unittest
+
+import test_s3
+
+SCRIPT = os.path.join(os.path.dirname(os.path.abspath(__file__)), "test_s3.py")
+SECRET_KEY = "known-secret-key-0123456789"
+
+
+def run_cli(*argv):
+    """Run test_s3.py against --mock, returning (exit code, stdout, stderr)."""
+    env = {name: value for name, value in os.environ.items() if not name.startswith("S3_")}
+    result = subprocess.run([sys.executable, SCRIPT, *argv, "--mock", "--access-key", "mock-access",
+                             "--secret-key", SECRET_KEY],
+                            capture_output=True, text=True, env=env, timeout=120)
+    return result.returncode, result.stdout, result.stderr
+
+
+class SecretKeyTest(unittest.TestCase):
+    def test_never_printed(self):
+        with tempfile.TemporaryDirectory() as directory:
+            report = os.path.join(directory, "report.json")
+            for output in ("text", "json", "jsonl"):
+                with self.subTest(output=output):
+                    code, stdout, stderr = run_cli("smoke", "-vv", "--output", output, "--report", report)
+                    self.assertEqual(code, test_s3.EXIT_OK, stderr)
+                    with open(report) as f:
+                        written = f.read()
+                    for text in (stdout, stderr, written):
+                        self.assertNotIn(SECRET_KEY, text)
+                    self.assertEqual(json.loads(written)["config"]["secret_key"], "****")
+
+    def test_never_printed_on_failure(self):
+        # The error path formats its own messages
+        code, stdout, stderr = run_cli("get", "--key", "missing", "--output", "json")
+        self.assertEqual(code, test_s3.EXIT_NOT_FOUND)
+        self.assertNotIn(SECRET_KEY, stdout + stderr)
+
+
+class RedactTest(unittest.TestCase):
+    def setUp(self):
+        self.saved = list(test_s3.SECRETS)
+
+    def tearDown(self):
+        test_s3.SECRETS[:] = self.saved
+
+    def test_short_secret_masked_as_token(self):
+        test_s3.add_secret("abc")
+        self.assertEqual(test_s3.redact('key=abc "abc" abc/x'), 'key=**** "****" ****/x')
+        # Inside a longer word it is left alone
+        self.assertEqual(test_s3.redact("tabcd"), "tabcd")
+
+    def test_long_secret_masked_anywhere(self):
+        test_s3.add_secret(SECRET_KEY)
+        self.assertEqual(test_s3.redact(f"x{SECRET_KEY}y"), "x****y")
+
+    def test_sse_c_key_forms(self):
+        raw = "0123456789abcdef0123456789abcdef"
+        encoded = base64.b64encode(raw.encode()).decode()
+        for given in (raw, encoded):
+            with self.subTest(given=given):
+                test_s3.SECRETS[:] = self.saved
+                key = test_s3.parse_sse_c_key(given)
+                masked = test_s3.redact(f"header {encoded} raw {raw} bytes {key}")
+                self.assertNotIn(raw, masked)
+                self.assertNotIn(encoded, masked)
+
+    def test_presigned_signature(self):
+        masked = test_s3.redact("http://rgw/b/k?X-Amz-Signature=abcdef0123&X-Amz-Expires=60")
+        self.assertEqual(masked, "http://rgw/b/k?X-Amz-Signature=****&X-Amz-Expires=60")
+
+
+if __name__ == "__main__":
+    unittest.main()